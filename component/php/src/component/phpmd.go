@@ -18,7 +18,10 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
 	"util/git"
 	"util/input"
 	"util/file"
@@ -30,7 +33,11 @@ const (
 	// baseCommand string = "phpmd"
 	baseCommand string = "/home/composer/.composer/vendor/bin/phpmd"
 	reportPath string = "/tmp/phpmd.xml"
+	jsonReportPath string = "/tmp/phpmd.json"
+	sarifReportPath string = "/tmp/phpmd.sarif"
 	reportFormat string = "REPORT"
+	snapshotPath string = basePath + "/.co-phpmd-snapshot.json"
+	cacheReportPath string = basePath + "/.co-phpmd-report-cache.json"
 )
 
 func main() {
@@ -45,6 +52,20 @@ func main() {
 		"suffixes",
 		"strict",
 		"ignore-violations-on-exit",
+		"report-format",
+		"fail-on-priority",
+		"max-violations",
+		"since",
+		"incremental",
+		"git-ref",
+		"git-depth",
+		"git-submodules",
+		"git-token-env",
+		"git-ssh-key",
+		"watch",
+		"poll-interval",
+		"baseline",
+		"baseline-write",
 	}
 
 	codata := map[string]string{}
@@ -55,13 +76,66 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err := git.Clone(codata["git-url"], basePath); err != nil {
+	cloneConfig := git.CloneConfig{
+		Ref:          codata["git-ref"],
+		Recursive:    codata["git-submodules"] == "true",
+		Quiet:        true,
+		SingleBranch: true,
+	}
+	if codata["git-depth"] != "" {
+		if depth, err := strconv.Atoi(codata["git-depth"]); err == nil {
+			cloneConfig.Depth = depth
+		}
+	}
+	if codata["git-token-env"] != "" || codata["git-ssh-key"] != "" {
+		cloneConfig.Credentials = &git.Credentials{
+			TokenEnv:   codata["git-token-env"],
+			SSHKeyPath: codata["git-ssh-key"],
+		}
+	}
+
+	if err := git.CloneOrUpdate(codata["git-url"], basePath, cloneConfig); err != nil {
 		fmt.Fprintf(os.Stderr, "[COUT] Clone the repository error: %s\n", err.Error())
 		fmt.Fprintf(os.Stdout, "[COUT] CO_RESULT = %s\n", "false")
 		os.Exit(1)
 	}
 
-	command := baseCommand
+	suffixes := []string{"php"}
+	if codata["suffixes"] != "" {
+		suffixes = strings.Split(codata["suffixes"], ",")
+	}
+
+	oldSnapshot, _ := loadSnapshot(snapshotPath)
+	incremental := (codata["incremental"] == "true" || codata["since"] != "") && oldSnapshot != nil
+
+	// changedFiles includes files that were deleted since the last scan, so
+	// their stale violations get pruned from the merged report below.
+	// existingChangedFiles is the subset still on disk, i.e. what can
+	// actually be handed to phpmd.
+	var changedFiles, existingChangedFiles []string
+	runPhpmd := true
+
+	if incremental {
+		var err error
+		if codata["since"] != "" {
+			changedFiles, err = gitChangedFiles(basePath, codata["since"])
+		} else {
+			var freshSnapshot *Snapshot
+			freshSnapshot, err = buildSnapshot(basePath, suffixes)
+			if err == nil {
+				changedFiles = diffSnapshots(oldSnapshot, freshSnapshot)
+			}
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[COUT] Compute changed files error: %s\n", err.Error())
+			incremental = false
+		} else {
+			changedFiles = filterBySuffix(changedFiles, suffixes)
+			existingChangedFiles = filterExisting(basePath, changedFiles)
+			runPhpmd = len(existingChangedFiles) > 0
+		}
+	}
 
 	if codata["path"] == "" {
 		codata["path"] = "."
@@ -72,7 +146,122 @@ func main() {
 	if codata["ruleset"] == "" {
 		codata["ruleset"] = "cleancode,codesize,controversial,design,naming,unusedcode"
 	}
-	command = fmt.Sprintf("%s %s %s %s", command, codata["path"], codata["formats"], codata["ruleset"])
+
+	scanPath := codata["path"]
+	if incremental && runPhpmd {
+		scanPath = strings.Join(existingChangedFiles, ",")
+	}
+
+	var report *Report
+	scanFailed := false
+
+	if runPhpmd {
+		partial, err := runPHPMD(scanPath, codata)
+		if err != nil {
+			scanFailed = true
+			// A scan error (e.g. one bad path in the batch) must not wipe
+			// out the violations we already know about for the rest of
+			// the tree: keep the prior report untouched and let the next
+			// run retry the diff.
+			fmt.Fprintf(os.Stderr, "[COUT] Incremental scan error, keeping prior report unchanged: %s\n", err.Error())
+			report = loadCachedReport(cacheReportPath)
+		} else if incremental {
+			report = mergeReports(loadCachedReport(cacheReportPath), partial, changedFiles)
+		} else {
+			report = partial
+		}
+	} else if incremental && len(changedFiles) > 0 {
+		// Every changed file was a deletion: nothing to scan, but their
+		// violations still need to be pruned from the cached report.
+		report = mergeReports(loadCachedReport(cacheReportPath), &Report{}, changedFiles)
+	} else {
+		// Nothing changed since the last scan: reuse the cached report as-is.
+		report = loadCachedReport(cacheReportPath)
+	}
+
+	if report != nil {
+		report = report.applySuppressions(basePath)
+
+		if codata["baseline-write"] != "" {
+			if err := report.toBaseline(basePath).save(codata["baseline-write"]); err != nil {
+				fmt.Fprintf(os.Stderr, "[COUT] Write baseline error: %s\n", err.Error())
+			}
+		} else if codata["baseline"] != "" {
+			if baseline, err := loadBaselineFile(codata["baseline"]); err == nil {
+				report = report.subtractBaseline(basePath, baseline)
+			} else {
+				fmt.Fprintf(os.Stderr, "[COUT] Load baseline error: %s\n", err.Error())
+			}
+		}
+	}
+
+	if scanFailed {
+		// Leave the cache and snapshot as they were, so the next run diffs
+		// the same files again; only re-emit the prior report as XML.
+		if report != nil {
+			if xml, err := report.ToXML(); err == nil {
+				ioutil.WriteFile(reportPath, xml, 0644)
+			}
+		}
+	} else {
+		persistReport(report, suffixes)
+	}
+
+	if codata["watch"] == "true" {
+		watchRun(codata, suffixes, report)
+		os.Exit(0)
+	}
+
+	if codata["report-format"] == "" {
+		codata["report-format"] = "xml"
+	}
+
+	for _, format := range strings.Split(codata["report-format"], ",") {
+		switch strings.TrimSpace(format) {
+		case "xml":
+			file.StdoutAll(reportPath, reportFormat)
+		case "json":
+			if report == nil {
+				continue
+			}
+			if summary, err := report.ToJSONSummary(); err == nil {
+				ioutil.WriteFile(jsonReportPath, summary, 0644)
+				file.StdoutAll(jsonReportPath, reportFormat)
+			}
+		case "sarif":
+			if report == nil {
+				continue
+			}
+			if sarif, err := report.ToSARIF(); err == nil {
+				ioutil.WriteFile(sarifReportPath, sarif, 0644)
+				file.StdoutAll(sarifReportPath, reportFormat)
+			}
+		}
+	}
+
+	result := "true"
+
+	if report != nil {
+		failOnPriority, _ := strconv.Atoi(codata["fail-on-priority"])
+		maxViolations, _ := strconv.Atoi(codata["max-violations"])
+
+		if report.ExceedsThresholds(failOnPriority, maxViolations) {
+			result = "false"
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "[COUT] CO_RESULT = %s\n", result)
+
+	if result == "false" {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// buildPHPMDCommand assembles the phpmd CLI invocation for a scan rooted at
+// scanPath (which may be a single path or a comma-separated file list).
+func buildPHPMDCommand(scanPath string, codata map[string]string) string {
+	command := fmt.Sprintf("%s %s %s %s", baseCommand, scanPath, codata["formats"], codata["ruleset"])
 
 	params := []string{
 		"minimumpriority",
@@ -98,14 +287,33 @@ func main() {
 		}
 	}
 
-	command = fmt.Sprintf("%s --reportfile %s", command, reportPath)
+	return fmt.Sprintf("%s --reportfile %s", command, reportPath)
+}
+
+// runPHPMD runs phpmd against scanPath and returns the parsed report.
+func runPHPMD(scanPath string, codata map[string]string) (*Report, error) {
+	command := buildPHPMDCommand(scanPath, codata)
 
 	if err := cmd.RunCommand(command, basePath); err != nil {
-		
+		return nil, err
 	}
 
-	file.StdoutAll(reportPath, reportFormat)
+	return parsePHPMDReport(reportPath, basePath)
+}
 
-	fmt.Fprintf(os.Stdout, "[COUT] CO_RESULT = %s\n", "true")
-	os.Exit(0)
+// persistReport writes report back to reportPath as XML (so a merged
+// incremental report still emits through the same "xml" path as a full
+// scan), caches it for the next incremental run, and refreshes the
+// fileset snapshot used to compute future diffs.
+func persistReport(report *Report, suffixes []string) {
+	if report != nil {
+		if xml, err := report.ToXML(); err == nil {
+			ioutil.WriteFile(reportPath, xml, 0644)
+		}
+		report.saveCache(cacheReportPath)
+	}
+
+	if newSnapshot, err := buildSnapshot(basePath, suffixes); err == nil {
+		newSnapshot.save(snapshotPath)
+	}
 }
\ No newline at end of file
@@ -0,0 +1,156 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// baselineEntry is one accepted, pre-existing violation.
+type baselineEntry struct {
+	Rule       string `json:"rule"`
+	File       string `json:"file"`
+	WindowHash string `json:"windowHash"`
+}
+
+// baselineFile is the JSON document persisted by baseline-write and
+// consumed by baseline.
+type baselineFile struct {
+	Entries []baselineEntry `json:"entries"`
+}
+
+func (b *baselineFile) has(entry baselineEntry) bool {
+	for _, e := range b.Entries {
+		if e.Rule == entry.Rule && e.File == entry.File && e.WindowHash == entry.WindowHash {
+			return true
+		}
+	}
+	return false
+}
+
+// loadBaselineFile reads a previously written baseline from disk.
+func loadBaselineFile(path string) (*baselineFile, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	baseline := &baselineFile{}
+	if err := json.Unmarshal(raw, baseline); err != nil {
+		return nil, err
+	}
+
+	return baseline, nil
+}
+
+// save writes the baseline as indented JSON so it diffs cleanly when
+// committed alongside the repo.
+func (b *baselineFile) save(path string) error {
+	raw, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, raw, 0644)
+}
+
+// windowHash fingerprints a violation by the trimmed content of its own
+// source line, not by its raw line number or a fixed-offset neighborhood of
+// surrounding lines. A positional window looks stable but isn't: inserting
+// or removing even one line anywhere above a violation shifts every
+// subsequent line number, which slides a fixed-offset window onto different
+// source and changes its hash even though the flagged line itself never
+// moved in any way that matters. Anchoring on the line's own trimmed text
+// instead survives that shift unconditionally, and still changes the hash
+// the one time it should: when the flagged line itself is edited.
+//
+// The known trade-off is that two violations of the same rule on otherwise
+// identical lines elsewhere in the file collide, and an edit to a
+// *neighboring* line alone won't invalidate the entry. Both are judged
+// acceptable for gradual-adoption baselining, where the goal is "is this
+// still the same flagged line", not "has anything nearby changed".
+func windowHash(repoRoot, file string, line int) string {
+	lines, err := readLines(filepath.Join(repoRoot, file))
+	if err != nil {
+		return ""
+	}
+
+	idx := line - 1
+	if idx < 0 || idx >= len(lines) {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(strings.TrimSpace(lines[idx])))
+	return hex.EncodeToString(sum[:])
+}
+
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	return lines, scanner.Err()
+}
+
+// toBaseline captures every violation in r as an accepted baseline entry.
+func (r *Report) toBaseline(repoRoot string) *baselineFile {
+	baseline := &baselineFile{}
+
+	for _, v := range r.Violations {
+		baseline.Entries = append(baseline.Entries, baselineEntry{
+			Rule:       v.Rule,
+			File:       v.File,
+			WindowHash: windowHash(repoRoot, v.File, v.Line),
+		})
+	}
+
+	return baseline
+}
+
+// subtractBaseline drops every violation in r that matches an entry in
+// baseline, leaving only violations that are new since the baseline was
+// written.
+func (r *Report) subtractBaseline(repoRoot string, baseline *baselineFile) *Report {
+	if baseline == nil {
+		return r
+	}
+
+	filtered := &Report{}
+	for _, v := range r.Violations {
+		entry := baselineEntry{Rule: v.Rule, File: v.File, WindowHash: windowHash(repoRoot, v.File, v.Line)}
+		if !baseline.has(entry) {
+			filtered.Violations = append(filtered.Violations, v)
+		}
+	}
+
+	return filtered
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeSourceFile(t *testing.T, repoRoot, name string, lines ...string) {
+	t.Helper()
+
+	contents := ""
+	for _, l := range lines {
+		contents += l + "\n"
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(repoRoot, name), []byte(contents), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func TestWindowHashSurvivesUnrelatedLineShift(t *testing.T) {
+	repoRoot, err := ioutil.TempDir("", "phpmd-baseline")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(repoRoot)
+
+	writeSourceFile(t, repoRoot, "a.php",
+		"<?php", "line2", "line3 violation here", "line4", "line5",
+	)
+	hashBefore := windowHash(repoRoot, "a.php", 3)
+
+	writeSourceFile(t, repoRoot, "a.php",
+		"<?php", "// a new comment", "line2", "line3 violation here", "line4", "line5",
+	)
+	hashAfter := windowHash(repoRoot, "a.php", 4)
+
+	if hashBefore == "" || hashAfter == "" {
+		t.Fatal("windowHash() returned empty hash")
+	}
+	if hashBefore != hashAfter {
+		t.Errorf("windowHash() = %q before shift, %q after, want equal", hashBefore, hashAfter)
+	}
+}
+
+func TestWindowHashChangesWhenViolatingLineIsEdited(t *testing.T) {
+	repoRoot, err := ioutil.TempDir("", "phpmd-baseline")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(repoRoot)
+
+	writeSourceFile(t, repoRoot, "a.php", "<?php", "line2", "violation", "line4", "line5")
+	hashBefore := windowHash(repoRoot, "a.php", 3)
+
+	writeSourceFile(t, repoRoot, "a.php", "<?php", "line2", "violation edited", "line4", "line5")
+	hashAfter := windowHash(repoRoot, "a.php", 3)
+
+	if hashBefore == hashAfter {
+		t.Error("windowHash() did not change after editing the violation's own line")
+	}
+}
+
+func TestWindowHashIgnoresNeighboringLineEdits(t *testing.T) {
+	repoRoot, err := ioutil.TempDir("", "phpmd-baseline")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(repoRoot)
+
+	writeSourceFile(t, repoRoot, "a.php", "<?php", "line2", "violation", "line4", "line5")
+	hashBefore := windowHash(repoRoot, "a.php", 3)
+
+	writeSourceFile(t, repoRoot, "a.php", "<?php", "line2 edited", "violation", "line4", "line5")
+	hashAfter := windowHash(repoRoot, "a.php", 3)
+
+	if hashBefore != hashAfter {
+		t.Error("windowHash() should only depend on the violation's own line, not its neighbors")
+	}
+}
+
+func TestToBaselineAndSubtractBaselineRoundTrip(t *testing.T) {
+	repoRoot, err := ioutil.TempDir("", "phpmd-baseline")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(repoRoot)
+
+	writeSourceFile(t, repoRoot, "a.php", "<?php", "line2", "violation", "line4", "line5")
+
+	report := &Report{Violations: []Violation{
+		{File: "a.php", Line: 3, Rule: "UnusedLocalVariable"},
+	}}
+
+	baseline := report.toBaseline(repoRoot)
+	if len(baseline.Entries) != 1 {
+		t.Fatalf("expected 1 baseline entry, got %d", len(baseline.Entries))
+	}
+
+	filtered := report.subtractBaseline(repoRoot, baseline)
+	if len(filtered.Violations) != 0 {
+		t.Errorf("subtractBaseline() = %+v, want empty (already baselined)", filtered.Violations)
+	}
+
+	newReport := &Report{Violations: []Violation{
+		{File: "a.php", Line: 3, Rule: "UnusedLocalVariable"},
+		{File: "a.php", Line: 3, Rule: "ShortVariable"},
+	}}
+
+	stillFlagged := newReport.subtractBaseline(repoRoot, baseline)
+	if len(stillFlagged.Violations) != 1 || stillFlagged.Violations[0].Rule != "ShortVariable" {
+		t.Errorf("subtractBaseline() = %+v, want only the new ShortVariable violation", stillFlagged.Violations)
+	}
+}
+
+func TestSubtractBaselineNilBaselineIsNoop(t *testing.T) {
+	report := &Report{Violations: []Violation{{File: "a.php", Rule: "R"}}}
+
+	got := report.subtractBaseline("/tmp", nil)
+	if len(got.Violations) != 1 {
+		t.Errorf("subtractBaseline(nil) = %+v, want report unchanged", got.Violations)
+	}
+}
+
+func TestBaselineFileSaveAndLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phpmd-baseline")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	baseline := &baselineFile{Entries: []baselineEntry{
+		{Rule: "R1", File: "a.php", WindowHash: "abc123"},
+	}}
+
+	path := filepath.Join(dir, "baseline.json")
+	if err := baseline.save(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	loaded, err := loadBaselineFile(path)
+	if err != nil {
+		t.Fatalf("loadBaselineFile: %v", err)
+	}
+
+	if !loaded.has(baseline.Entries[0]) {
+		t.Errorf("loaded baseline missing entry %+v", baseline.Entries[0])
+	}
+}
@@ -0,0 +1,329 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Violation is a single phpmd finding normalized out of the tool's raw XML
+// report, so it can be re-emitted in whatever format the caller asked for.
+type Violation struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Rule     string `json:"rule"`
+	RuleSet  string `json:"ruleSet"`
+	Priority int    `json:"priority"`
+	Message  string `json:"message"`
+}
+
+// Report is the parsed form of a full phpmd run.
+type Report struct {
+	Violations []Violation `json:"violations"`
+}
+
+// pmdXML and friends mirror the subset of phpmd's `--reportfile xml` schema
+// that we care about.
+type pmdXML struct {
+	XMLName xml.Name    `xml:"pmd"`
+	Files   []pmdFileXML `xml:"file"`
+}
+
+type pmdFileXML struct {
+	Name       string          `xml:"name,attr"`
+	Violations []violationXML `xml:"violation"`
+}
+
+type violationXML struct {
+	BeginLine int    `xml:"beginline,attr"`
+	Rule      string `xml:"rule,attr"`
+	RuleSet   string `xml:"ruleset,attr"`
+	Priority  int    `xml:"priority,attr"`
+	Message   string `xml:",chardata"`
+}
+
+// parsePHPMDReport reads phpmd's raw XML report from disk and normalizes it
+// into a Report. File paths are rewritten relative to repoRoot so every
+// consumer (merge-by-filename, baseline window hashing, suppression
+// lookups) sees a stable key regardless of whether phpmd was invoked with
+// "." for a full scan or an explicit file list for an incremental one.
+func parsePHPMDReport(path, repoRoot string) (*Report, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed pmdXML
+	if err := xml.Unmarshal(raw, &parsed); err != nil {
+		return nil, err
+	}
+
+	report := &Report{}
+	for _, f := range parsed.Files {
+		for _, v := range f.Violations {
+			report.Violations = append(report.Violations, Violation{
+				File:     normalizeFilePath(repoRoot, f.Name),
+				Line:     v.BeginLine,
+				Rule:     v.Rule,
+				RuleSet:  v.RuleSet,
+				Priority: v.Priority,
+				Message:  strings.TrimSpace(v.Message),
+			})
+		}
+	}
+
+	return report, nil
+}
+
+// normalizeFilePath rewrites raw (as reported by phpmd, which may be
+// absolute or relative depending on how it was invoked) as a path relative
+// to repoRoot, using forward slashes so it's stable across platforms.
+func normalizeFilePath(repoRoot, raw string) string {
+	repoAbs, err := filepath.Abs(repoRoot)
+	if err != nil {
+		return filepath.ToSlash(filepath.Clean(raw))
+	}
+
+	rawAbs := raw
+	if !filepath.IsAbs(raw) {
+		rawAbs, err = filepath.Abs(filepath.Join(repoRoot, raw))
+		if err != nil {
+			return filepath.ToSlash(filepath.Clean(raw))
+		}
+	}
+
+	rel, err := filepath.Rel(repoAbs, rawAbs)
+	if err != nil {
+		return filepath.ToSlash(filepath.Clean(raw))
+	}
+
+	return filepath.ToSlash(rel)
+}
+
+// ToXML re-serializes the report back into phpmd's own `pmd` XML schema, so
+// a merged report (incremental scan + cached prior violations) can still be
+// emitted through the same "xml" report-format as a full scan.
+func (r *Report) ToXML() ([]byte, error) {
+	byFile := map[string]*pmdFileXML{}
+	var order []string
+
+	for _, v := range r.Violations {
+		f, ok := byFile[v.File]
+		if !ok {
+			f = &pmdFileXML{Name: v.File}
+			byFile[v.File] = f
+			order = append(order, v.File)
+		}
+
+		f.Violations = append(f.Violations, violationXML{
+			BeginLine: v.Line,
+			Rule:      v.Rule,
+			RuleSet:   v.RuleSet,
+			Priority:  v.Priority,
+			Message:   v.Message,
+		})
+	}
+
+	parsed := pmdXML{}
+	for _, name := range order {
+		parsed.Files = append(parsed.Files, *byFile[name])
+	}
+
+	raw, err := xml.MarshalIndent(parsed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), raw...), nil
+}
+
+// ExceedsThresholds reports whether the violations in this report should
+// fail the build under the given gates. A zero value for either gate means
+// "no limit".
+func (r *Report) ExceedsThresholds(failOnPriority, maxViolations int) bool {
+	if maxViolations > 0 && len(r.Violations) > maxViolations {
+		return true
+	}
+
+	if failOnPriority > 0 {
+		for _, v := range r.Violations {
+			if v.Priority <= failOnPriority {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// loadCachedReport reads back a Report previously persisted with
+// saveCache. A missing or unreadable cache simply yields no prior
+// violations to merge against.
+func loadCachedReport(path string) *Report {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	report := &Report{}
+	if err := json.Unmarshal(raw, report); err != nil {
+		return nil
+	}
+
+	return report
+}
+
+// saveCache persists the report so the next incremental run can merge its
+// unchanged-file violations back in.
+func (r *Report) saveCache(path string) error {
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, raw, 0644)
+}
+
+// jsonSummary is the compact, file-keyed summary emitted for
+// "report-format=json".
+type jsonSummary struct {
+	Total int                    `json:"total"`
+	Files map[string][]Violation `json:"files"`
+}
+
+// ToJSONSummary groups the violations by file and renders them as indented
+// JSON.
+func (r *Report) ToJSONSummary() ([]byte, error) {
+	summary := jsonSummary{
+		Total: len(r.Violations),
+		Files: map[string][]Violation{},
+	}
+
+	for _, v := range r.Violations {
+		summary.Files[v.File] = append(summary.Files[v.File], v)
+	}
+
+	return json.MarshalIndent(summary, "", "  ")
+}
+
+// SARIF 2.1.0 types, kept intentionally minimal: only the fields code
+// scanning dashboards (GitHub, Azure DevOps) actually read.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// sarifLevel maps a phpmd priority (1 highest .. 5 lowest) onto the three
+// SARIF severity levels.
+func sarifLevel(priority int) string {
+	switch {
+	case priority <= 1:
+		return "error"
+	case priority <= 3:
+		return "warning"
+	default:
+		return "note"
+	}
+}
+
+// ToSARIF renders the report as a SARIF 2.1.0 log.
+func (r *Report) ToSARIF() ([]byte, error) {
+	rules := map[string]bool{}
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{Name: "phpmd"}},
+	}
+
+	for _, v := range r.Violations {
+		if !rules[v.Rule] {
+			rules[v.Rule] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: v.Rule})
+		}
+
+		run.Results = append(run.Results, sarifResult{
+			RuleID:  v.Rule,
+			Level:   sarifLevel(v.Priority),
+			Message: sarifMessage{Text: v.Message},
+			Locations: []sarifLocation{
+				{
+					PhysicalLocation: sarifPhysicalLocation{
+						ArtifactLocation: sarifArtifactLocation{URI: v.File},
+						Region:           sarifRegion{StartLine: v.Line},
+					},
+				},
+			},
+		})
+	}
+
+	log := sarifLog{
+		Schema:  "https://raw.githubusercontent.com/oasis-tcs/sarif-spec/master/Schemata/sarif-schema-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+
+	return json.MarshalIndent(log, "", "  ")
+}
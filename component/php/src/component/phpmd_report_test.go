@@ -0,0 +1,219 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const samplePMDXML = `<?xml version="1.0" encoding="UTF-8"?>
+<pmd version="2.8.2" timestamp="2017-01-01T00:00:00+00:00">
+  <file name="%s">
+    <violation beginline="12" endline="12" rule="UnusedLocalVariable" ruleset="Unused Code Rules" priority="3">
+      Avoid unused local variables such as '$foo'.
+    </violation>
+  </file>
+</pmd>
+`
+
+func writeTempReport(t *testing.T, repoRoot, fileAttr string) string {
+	t.Helper()
+
+	reportFile := filepath.Join(repoRoot, "phpmd.xml")
+	contents := []byte(fmt.Sprintf(samplePMDXML, fileAttr))
+
+	if err := ioutil.WriteFile(reportFile, contents, 0644); err != nil {
+		t.Fatalf("write temp report: %v", err)
+	}
+
+	return reportFile
+}
+
+func TestParsePHPMDReportNormalizesAbsolutePath(t *testing.T) {
+	repoRoot, err := ioutil.TempDir("", "phpmd-report")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(repoRoot)
+
+	phpFile := filepath.Join(repoRoot, "src", "Foo.php")
+	if err := os.MkdirAll(filepath.Dir(phpFile), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := ioutil.WriteFile(phpFile, []byte("<?php\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	reportFile := writeTempReport(t, repoRoot, phpFile)
+
+	report, err := parsePHPMDReport(reportFile, repoRoot)
+	if err != nil {
+		t.Fatalf("parsePHPMDReport: %v", err)
+	}
+
+	if len(report.Violations) != 1 {
+		t.Fatalf("expected 1 violation, got %d", len(report.Violations))
+	}
+
+	want := "src/Foo.php"
+	if got := report.Violations[0].File; got != want {
+		t.Errorf("File = %q, want %q", got, want)
+	}
+}
+
+func TestParsePHPMDReportNormalizesRelativePath(t *testing.T) {
+	repoRoot, err := ioutil.TempDir("", "phpmd-report")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(repoRoot)
+
+	reportFile := writeTempReport(t, repoRoot, "src/Foo.php")
+
+	report, err := parsePHPMDReport(reportFile, repoRoot)
+	if err != nil {
+		t.Fatalf("parsePHPMDReport: %v", err)
+	}
+
+	if len(report.Violations) != 1 {
+		t.Fatalf("expected 1 violation, got %d", len(report.Violations))
+	}
+
+	want := "src/Foo.php"
+	if got := report.Violations[0].File; got != want {
+		t.Errorf("File = %q, want %q", got, want)
+	}
+}
+
+func TestReportToXMLRoundTrip(t *testing.T) {
+	repoRoot, err := ioutil.TempDir("", "phpmd-report")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(repoRoot)
+
+	original := &Report{Violations: []Violation{
+		{File: "src/Foo.php", Line: 12, Rule: "UnusedLocalVariable", RuleSet: "Unused Code Rules", Priority: 3, Message: "unused var"},
+	}}
+
+	xmlBytes, err := original.ToXML()
+	if err != nil {
+		t.Fatalf("ToXML: %v", err)
+	}
+
+	reportFile := filepath.Join(repoRoot, "roundtrip.xml")
+	if err := ioutil.WriteFile(reportFile, xmlBytes, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	reparsed, err := parsePHPMDReport(reportFile, repoRoot)
+	if err != nil {
+		t.Fatalf("parsePHPMDReport: %v", err)
+	}
+
+	if len(reparsed.Violations) != 1 {
+		t.Fatalf("expected 1 violation, got %d", len(reparsed.Violations))
+	}
+
+	got := reparsed.Violations[0]
+	want := original.Violations[0]
+	if got.Rule != want.Rule || got.Line != want.Line || got.Priority != want.Priority {
+		t.Errorf("round-tripped violation = %+v, want %+v", got, want)
+	}
+}
+
+func TestExceedsThresholds(t *testing.T) {
+	report := &Report{Violations: []Violation{
+		{Priority: 1},
+		{Priority: 4},
+	}}
+
+	cases := []struct {
+		name           string
+		failOnPriority int
+		maxViolations  int
+		want           bool
+	}{
+		{"no gates", 0, 0, false},
+		{"priority gate trips", 2, 0, true},
+		{"priority gate disabled", 0, 0, false},
+		{"count gate trips", 0, 1, true},
+		{"count gate does not trip", 0, 5, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := report.ExceedsThresholds(c.failOnPriority, c.maxViolations); got != c.want {
+				t.Errorf("ExceedsThresholds(%d, %d) = %v, want %v", c.failOnPriority, c.maxViolations, got, c.want)
+			}
+		})
+	}
+}
+
+func TestToJSONSummaryGroupsByFile(t *testing.T) {
+	report := &Report{Violations: []Violation{
+		{File: "a.php", Rule: "R1"},
+		{File: "a.php", Rule: "R2"},
+		{File: "b.php", Rule: "R1"},
+	}}
+
+	raw, err := report.ToJSONSummary()
+	if err != nil {
+		t.Fatalf("ToJSONSummary: %v", err)
+	}
+
+	if len(raw) == 0 {
+		t.Fatal("expected non-empty JSON output")
+	}
+
+	var summary jsonSummary
+	if err := json.Unmarshal(raw, &summary); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if summary.Total != 3 || len(summary.Files) != 2 || len(summary.Files["a.php"]) != 2 || len(summary.Files["b.php"]) != 1 {
+		t.Errorf("ToJSONSummary() = %+v, want 3 violations grouped as a.php:2, b.php:1", summary)
+	}
+}
+
+func TestToSARIFListsEachRuleOnce(t *testing.T) {
+	report := &Report{Violations: []Violation{
+		{File: "a.php", Rule: "R1", Priority: 1},
+		{File: "b.php", Rule: "R1", Priority: 5},
+	}}
+
+	raw, err := report.ToSARIF()
+	if err != nil {
+		t.Fatalf("ToSARIF: %v", err)
+	}
+
+	if len(raw) == 0 {
+		t.Fatal("expected non-empty SARIF output")
+	}
+
+	var log sarifLog
+	if err := json.Unmarshal(raw, &log); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if len(log.Runs) != 1 || len(log.Runs[0].Tool.Driver.Rules) != 1 || len(log.Runs[0].Results) != 2 {
+		t.Errorf("ToSARIF() = %+v, want one run listing rule R1 once with 2 results", log)
+	}
+}
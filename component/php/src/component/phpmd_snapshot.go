@@ -0,0 +1,272 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// FileEntry is one tracked file as of a given scan, used to detect which
+// files changed between two runs without re-running phpmd on everything.
+type FileEntry struct {
+	Path    string `json:"path"`
+	SHA256  string `json:"sha256"`
+	ModTime int64  `json:"modTime"`
+	BlobOID string `json:"blobOid"`
+}
+
+// Snapshot is a fileset captured at the end of a scan, persisted so the
+// next run can diff against it instead of walking the whole tree.
+type Snapshot struct {
+	Files map[string]FileEntry `json:"files"`
+}
+
+// loadSnapshot reads a previously persisted Snapshot. A missing file is not
+// an error: it just means there is nothing to diff against yet.
+func loadSnapshot(path string) (*Snapshot, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(raw, snapshot); err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// save persists the Snapshot as indented JSON.
+func (s *Snapshot) save(path string) error {
+	raw, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, raw, 0644)
+}
+
+// buildSnapshot walks the git-tracked files under repoPath (so .gitignore
+// is honored for free), keeping only those whose extension matches one of
+// suffixes, and records their content hash, mtime and git blob OID.
+func buildSnapshot(repoPath string, suffixes []string) (*Snapshot, error) {
+	entries, err := lsTreeBlobs(repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{Files: map[string]FileEntry{}}
+
+	for relPath, blobOID := range entries {
+		if !hasSuffix(relPath, suffixes) {
+			continue
+		}
+
+		absPath := filepath.Join(repoPath, relPath)
+
+		info, err := os.Stat(absPath)
+		if err != nil {
+			continue
+		}
+
+		sha, err := sha256File(absPath)
+		if err != nil {
+			continue
+		}
+
+		snapshot.Files[relPath] = FileEntry{
+			Path:    relPath,
+			SHA256:  sha,
+			ModTime: info.ModTime().Unix(),
+			BlobOID: blobOID,
+		}
+	}
+
+	return snapshot, nil
+}
+
+// filterExisting keeps only the paths that still exist on disk under
+// repoPath, so a phpmd scan is never asked to analyze a deleted file.
+func filterExisting(repoPath string, paths []string) []string {
+	var existing []string
+	for _, p := range paths {
+		if _, err := os.Stat(filepath.Join(repoPath, p)); err == nil {
+			existing = append(existing, p)
+		}
+	}
+	return existing
+}
+
+// filterBySuffix keeps only the paths matching one of suffixes.
+func filterBySuffix(paths []string, suffixes []string) []string {
+	var filtered []string
+	for _, p := range paths {
+		if hasSuffix(p, suffixes) {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
+}
+
+// hasSuffix reports whether path ends in one of the given suffixes. An
+// empty suffix list matches everything.
+func hasSuffix(path string, suffixes []string) bool {
+	if len(suffixes) == 0 {
+		return true
+	}
+
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(path, "."+strings.TrimPrefix(strings.TrimSpace(suffix), ".")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sha256File hashes a file's contents.
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// lsTreeBlobs returns a map of repo-relative path -> blob OID for every
+// file git tracks at HEAD.
+func lsTreeBlobs(repoPath string) (map[string]string, error) {
+	cmd := exec.Command("git", "ls-tree", "-r", "HEAD")
+	cmd.Dir = repoPath
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]string{}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		// <mode> SP <type> SP <oid> TAB <path>
+		line := scanner.Text()
+		tab := strings.IndexByte(line, '\t')
+		if tab < 0 {
+			continue
+		}
+
+		meta := strings.Fields(line[:tab])
+		if len(meta) != 3 {
+			continue
+		}
+
+		entries[line[tab+1:]] = meta[2]
+	}
+
+	return entries, nil
+}
+
+// gitChangedFiles returns the paths that differ between ref and HEAD,
+// using plain `git diff --name-only`.
+func gitChangedFiles(repoPath, ref string) ([]string, error) {
+	cmd := exec.Command("git", "diff", "--name-only", ref+"..HEAD")
+	cmd.Dir = repoPath
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			files = append(files, line)
+		}
+	}
+
+	return files, nil
+}
+
+// diffSnapshots returns the paths that are new, whose blob OID changed, or
+// that were removed between old and current. Removed paths are included
+// so their stale violations get pruned from the cached report instead of
+// being carried forward forever.
+func diffSnapshots(old, current *Snapshot) []string {
+	var changed []string
+
+	for path, entry := range current.Files {
+		prev, ok := old.Files[path]
+		if !ok || prev.BlobOID != entry.BlobOID {
+			changed = append(changed, path)
+		}
+	}
+
+	for path := range old.Files {
+		if _, ok := current.Files[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+
+	return changed
+}
+
+// mergeReports replaces the violations belonging to changedFiles with the
+// ones from partial (a phpmd run restricted to those files), keeping every
+// other file's violations from prior untouched.
+func mergeReports(prior, partial *Report, changedFiles []string) *Report {
+	changed := map[string]bool{}
+	for _, f := range changedFiles {
+		changed[f] = true
+	}
+
+	merged := &Report{}
+
+	if prior != nil {
+		for _, v := range prior.Violations {
+			if !changed[v.File] {
+				merged.Violations = append(merged.Violations, v)
+			}
+		}
+	}
+
+	if partial != nil {
+		merged.Violations = append(merged.Violations, partial.Violations...)
+	}
+
+	return merged
+}
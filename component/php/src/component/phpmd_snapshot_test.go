@@ -0,0 +1,143 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestDiffSnapshotsDetectsAddedChangedAndDeleted(t *testing.T) {
+	old := &Snapshot{Files: map[string]FileEntry{
+		"a.php": {Path: "a.php", BlobOID: "oid-a"},
+		"b.php": {Path: "b.php", BlobOID: "oid-b"},
+	}}
+
+	current := &Snapshot{Files: map[string]FileEntry{
+		"a.php": {Path: "a.php", BlobOID: "oid-a-changed"},
+		"c.php": {Path: "c.php", BlobOID: "oid-c"},
+	}}
+
+	changed := diffSnapshots(old, current)
+	sort.Strings(changed)
+
+	want := []string{"a.php", "b.php", "c.php"}
+	if len(changed) != len(want) {
+		t.Fatalf("diffSnapshots() = %v, want %v", changed, want)
+	}
+	for i, w := range want {
+		if changed[i] != w {
+			t.Errorf("diffSnapshots()[%d] = %q, want %q", i, changed[i], w)
+		}
+	}
+}
+
+func TestDiffSnapshotsNoChanges(t *testing.T) {
+	old := &Snapshot{Files: map[string]FileEntry{
+		"a.php": {Path: "a.php", BlobOID: "oid-a"},
+	}}
+	current := &Snapshot{Files: map[string]FileEntry{
+		"a.php": {Path: "a.php", BlobOID: "oid-a"},
+	}}
+
+	if changed := diffSnapshots(old, current); len(changed) != 0 {
+		t.Errorf("diffSnapshots() = %v, want empty", changed)
+	}
+}
+
+func TestFilterExistingSkipsDeletedFiles(t *testing.T) {
+	repoRoot, err := ioutil.TempDir("", "phpmd-snapshot")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(repoRoot)
+
+	if err := ioutil.WriteFile(filepath.Join(repoRoot, "present.php"), []byte("<?php\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	got := filterExisting(repoRoot, []string{"present.php", "gone.php"})
+
+	if len(got) != 1 || got[0] != "present.php" {
+		t.Errorf("filterExisting() = %v, want [present.php]", got)
+	}
+}
+
+func TestFilterBySuffix(t *testing.T) {
+	paths := []string{"a.php", "b.txt", "c.inc"}
+
+	got := filterBySuffix(paths, []string{"php", "inc"})
+
+	want := []string{"a.php", "c.inc"}
+	if len(got) != len(want) {
+		t.Fatalf("filterBySuffix() = %v, want %v", got, want)
+	}
+	for i, w := range want {
+		if got[i] != w {
+			t.Errorf("filterBySuffix()[%d] = %q, want %q", i, got[i], w)
+		}
+	}
+}
+
+func TestMergeReportsReplacesOnlyChangedFiles(t *testing.T) {
+	prior := &Report{Violations: []Violation{
+		{File: "a.php", Rule: "Stale"},
+		{File: "b.php", Rule: "Untouched"},
+	}}
+
+	partial := &Report{Violations: []Violation{
+		{File: "a.php", Rule: "Fresh"},
+	}}
+
+	merged := mergeReports(prior, partial, []string{"a.php"})
+
+	if len(merged.Violations) != 2 {
+		t.Fatalf("expected 2 violations, got %d: %+v", len(merged.Violations), merged.Violations)
+	}
+
+	var sawFresh, sawUntouched bool
+	for _, v := range merged.Violations {
+		if v.File == "a.php" && v.Rule == "Fresh" {
+			sawFresh = true
+		}
+		if v.File == "b.php" && v.Rule == "Untouched" {
+			sawUntouched = true
+		}
+		if v.File == "a.php" && v.Rule == "Stale" {
+			t.Error("stale violation for changed file a.php was not replaced")
+		}
+	}
+	if !sawFresh || !sawUntouched {
+		t.Errorf("merged violations = %+v, missing expected entries", merged.Violations)
+	}
+}
+
+func TestMergeReportsPrunesDeletedFiles(t *testing.T) {
+	prior := &Report{Violations: []Violation{
+		{File: "deleted.php", Rule: "Stale"},
+		{File: "kept.php", Rule: "Untouched"},
+	}}
+
+	merged := mergeReports(prior, &Report{}, []string{"deleted.php"})
+
+	if len(merged.Violations) != 1 || merged.Violations[0].File != "kept.php" {
+		t.Errorf("mergeReports() = %+v, want only kept.php", merged.Violations)
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const ignoreFileName = ".phpmd-ignore"
+
+// ignoreRule is one line of a .phpmd-ignore file: a glob matched against
+// the violation's file path, plus the rule names it suppresses there.
+// A rule of "*" suppresses every rule for matching files.
+type ignoreRule struct {
+	Glob  string
+	Rules map[string]bool
+}
+
+func (r ignoreRule) suppresses(rule string) bool {
+	return r.Rules["*"] || r.Rules[rule]
+}
+
+// loadIgnoreFile parses a top-level .phpmd-ignore file made of
+// "<glob> <rule1,rule2>" lines. A missing file means no rules.
+func loadIgnoreFile(repoRoot string) []ignoreRule {
+	f, err := os.Open(filepath.Join(repoRoot, ignoreFileName))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		ruleSet := map[string]bool{}
+		for _, name := range strings.Split(fields[1], ",") {
+			ruleSet[strings.TrimSpace(name)] = true
+		}
+
+		rules = append(rules, ignoreRule{Glob: fields[0], Rules: ruleSet})
+	}
+
+	return rules
+}
+
+// matchesIgnoreFile reports whether any ignore rule suppresses this
+// violation.
+func matchesIgnoreFile(rules []ignoreRule, v Violation) bool {
+	for _, rule := range rules {
+		if matched, _ := filepath.Match(rule.Glob, v.File); matched && rule.suppresses(v.Rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// suppressDirective is the inline marker recognized in PHP source,
+// e.g. "// @phpmd-suppress UnusedLocalVariable,ShortVariable".
+const suppressDirective = "@phpmd-suppress"
+
+// hasInlineSuppress reports whether the source line the violation was
+// raised on (or the line immediately above it, the common place to annotate
+// the following statement) carries a "@phpmd-suppress" comment naming this
+// rule.
+func hasInlineSuppress(repoRoot string, v Violation) bool {
+	lines, err := readLines(filepath.Join(repoRoot, v.File))
+	if err != nil {
+		return false
+	}
+
+	for _, idx := range []int{v.Line - 1, v.Line - 2} {
+		if idx < 0 || idx >= len(lines) {
+			continue
+		}
+
+		if suppressedRules, ok := parseSuppressComment(lines[idx]); ok {
+			if suppressedRules["*"] || suppressedRules[v.Rule] {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// parseSuppressComment extracts the rule list from a "@phpmd-suppress"
+// comment, if the line has one.
+func parseSuppressComment(line string) (map[string]bool, bool) {
+	idx := strings.Index(line, suppressDirective)
+	if idx < 0 {
+		return nil, false
+	}
+
+	rest := strings.TrimSpace(line[idx+len(suppressDirective):])
+	rest = strings.TrimSuffix(rest, "*/")
+	rest = strings.TrimSpace(rest)
+
+	if rest == "" {
+		return map[string]bool{"*": true}, true
+	}
+
+	rules := map[string]bool{}
+	for _, name := range strings.Split(rest, ",") {
+		rules[strings.TrimSpace(name)] = true
+	}
+
+	return rules, true
+}
+
+// applySuppressions drops violations suppressed either by a
+// .phpmd-ignore entry or an inline "@phpmd-suppress" comment.
+func (r *Report) applySuppressions(repoRoot string) *Report {
+	ignoreRules := loadIgnoreFile(repoRoot)
+
+	filtered := &Report{}
+	for _, v := range r.Violations {
+		if matchesIgnoreFile(ignoreRules, v) || hasInlineSuppress(repoRoot, v) {
+			continue
+		}
+		filtered.Violations = append(filtered.Violations, v)
+	}
+
+	return filtered
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadIgnoreFileParsesGlobAndRules(t *testing.T) {
+	repoRoot, err := ioutil.TempDir("", "phpmd-suppress")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(repoRoot)
+
+	contents := "# comment\nvendor/*.php *\nsrc/Legacy.php UnusedLocalVariable,ShortVariable\n"
+	if err := ioutil.WriteFile(filepath.Join(repoRoot, ignoreFileName), []byte(contents), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	rules := loadIgnoreFile(repoRoot)
+	if len(rules) != 2 {
+		t.Fatalf("expected 2 rules, got %d: %+v", len(rules), rules)
+	}
+
+	if !matchesIgnoreFile(rules, Violation{File: "vendor/anything.php", Rule: "AnyRule"}) {
+		t.Error("wildcard glob+rule did not suppress vendor/anything.php")
+	}
+	if !matchesIgnoreFile(rules, Violation{File: "src/Legacy.php", Rule: "ShortVariable"}) {
+		t.Error("named rule did not suppress src/Legacy.php:ShortVariable")
+	}
+	if matchesIgnoreFile(rules, Violation{File: "src/Legacy.php", Rule: "UnusedCode"}) {
+		t.Error("unlisted rule was unexpectedly suppressed for src/Legacy.php")
+	}
+	if matchesIgnoreFile(rules, Violation{File: "src/Other.php", Rule: "UnusedLocalVariable"}) {
+		t.Error("rule matched a file not listed in .phpmd-ignore")
+	}
+}
+
+func TestLoadIgnoreFileMissingIsEmpty(t *testing.T) {
+	repoRoot, err := ioutil.TempDir("", "phpmd-suppress")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(repoRoot)
+
+	if rules := loadIgnoreFile(repoRoot); rules != nil {
+		t.Errorf("loadIgnoreFile() = %v, want nil for missing file", rules)
+	}
+}
+
+func TestHasInlineSuppressMatchesPriorLine(t *testing.T) {
+	repoRoot, err := ioutil.TempDir("", "phpmd-suppress")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(repoRoot)
+
+	writeSourceFile(t, repoRoot, "a.php",
+		"<?php",
+		"// @phpmd-suppress UnusedLocalVariable",
+		"$foo = 1;",
+	)
+
+	v := Violation{File: "a.php", Line: 3, Rule: "UnusedLocalVariable"}
+	if !hasInlineSuppress(repoRoot, v) {
+		t.Error("expected inline suppress comment on the line above to suppress the violation")
+	}
+
+	other := Violation{File: "a.php", Line: 3, Rule: "ShortVariable"}
+	if hasInlineSuppress(repoRoot, other) {
+		t.Error("suppress comment naming a different rule should not suppress ShortVariable")
+	}
+}
+
+func TestHasInlineSuppressWildcard(t *testing.T) {
+	repoRoot, err := ioutil.TempDir("", "phpmd-suppress")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(repoRoot)
+
+	writeSourceFile(t, repoRoot, "a.php", "<?php", "$foo = 1; // @phpmd-suppress")
+
+	v := Violation{File: "a.php", Line: 2, Rule: "AnyRule"}
+	if !hasInlineSuppress(repoRoot, v) {
+		t.Error("bare @phpmd-suppress comment should suppress every rule on its line")
+	}
+}
+
+func TestApplySuppressionsCombinesIgnoreFileAndInline(t *testing.T) {
+	repoRoot, err := ioutil.TempDir("", "phpmd-suppress")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(repoRoot)
+
+	if err := ioutil.WriteFile(filepath.Join(repoRoot, ignoreFileName), []byte("b.php *\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	writeSourceFile(t, repoRoot, "a.php", "<?php", "// @phpmd-suppress UnusedLocalVariable", "$foo = 1;")
+	writeSourceFile(t, repoRoot, "c.php", "<?php", "$bar = 1;")
+
+	report := &Report{Violations: []Violation{
+		{File: "a.php", Line: 3, Rule: "UnusedLocalVariable"},
+		{File: "b.php", Line: 1, Rule: "AnyRule"},
+		{File: "c.php", Line: 2, Rule: "UnusedLocalVariable"},
+	}}
+
+	filtered := report.applySuppressions(repoRoot)
+
+	if len(filtered.Violations) != 1 || filtered.Violations[0].File != "c.php" {
+		t.Errorf("applySuppressions() = %+v, want only the c.php violation to survive", filtered.Violations)
+	}
+}
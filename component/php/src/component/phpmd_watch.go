@@ -0,0 +1,330 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const defaultPollInterval = 30 * time.Second
+
+// watchMetrics tracks the counters exposed on /metrics, guarded by a mutex
+// since they're updated from the watch loop and read from HTTP handlers
+// running on a different goroutine.
+type watchMetrics struct {
+	mu               sync.Mutex
+	scanCount        int
+	lastScanDuration time.Duration
+	violationTotal   int
+}
+
+func (m *watchMetrics) record(duration time.Duration, violations int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.scanCount++
+	m.lastScanDuration = duration
+	m.violationTotal = violations
+}
+
+func (m *watchMetrics) writePrometheus(w http.ResponseWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP phpmd_scan_count Number of scans performed since the watcher started.\n")
+	fmt.Fprintf(w, "# TYPE phpmd_scan_count counter\n")
+	fmt.Fprintf(w, "phpmd_scan_count %d\n", m.scanCount)
+	fmt.Fprintf(w, "# HELP phpmd_last_scan_duration_seconds Duration of the most recent scan.\n")
+	fmt.Fprintf(w, "# TYPE phpmd_last_scan_duration_seconds gauge\n")
+	fmt.Fprintf(w, "phpmd_last_scan_duration_seconds %f\n", m.lastScanDuration.Seconds())
+	fmt.Fprintf(w, "# HELP phpmd_violation_total Current total violation count across the tree.\n")
+	fmt.Fprintf(w, "# TYPE phpmd_violation_total gauge\n")
+	fmt.Fprintf(w, "phpmd_violation_total %d\n", m.violationTotal)
+}
+
+// backoff implements exponential backoff with jitter and a cap on total
+// elapsed retry time, used to avoid hammering the remote on fetch errors.
+type backoff struct {
+	initial    time.Duration
+	max        time.Duration
+	multiplier float64
+	maxElapsed time.Duration
+
+	current time.Duration
+	elapsed time.Duration
+}
+
+func newBackoff() *backoff {
+	return &backoff{
+		initial:    time.Second,
+		max:        2 * time.Minute,
+		multiplier: 2,
+		maxElapsed: 15 * time.Minute,
+	}
+}
+
+// next returns the delay to wait before the next retry, and advances the
+// internal state. ok is false once maxElapsed has been exceeded, meaning
+// the caller should stop retrying.
+func (b *backoff) next() (delay time.Duration, ok bool) {
+	if b.elapsed >= b.maxElapsed {
+		return 0, false
+	}
+
+	if b.current == 0 {
+		b.current = b.initial
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(b.current) + 1))
+	delay = b.current/2 + jitter/2
+
+	b.elapsed += delay
+	b.current = time.Duration(float64(b.current) * b.multiplier)
+	if b.current > b.max {
+		b.current = b.max
+	}
+
+	return delay, true
+}
+
+func (b *backoff) reset() {
+	b.current = 0
+	b.elapsed = 0
+}
+
+// deltaEvent is one newline-delimited JSON line streamed to stdout each
+// time watch mode picks up new commits that add or remove violations; new
+// commits that leave the violation set unchanged emit no event.
+type deltaEvent struct {
+	HeadSHA string      `json:"headSha"`
+	Added   []Violation `json:"added"`
+	Removed []Violation `json:"removed"`
+}
+
+// diffViolations returns the violations present in next but not prev
+// (added) and present in prev but not next (removed).
+func diffViolations(prev, next *Report) (added, removed []Violation) {
+	key := func(v Violation) string {
+		return fmt.Sprintf("%s:%d:%s:%s", v.File, v.Line, v.Rule, v.Message)
+	}
+
+	prevSet := map[string]bool{}
+	if prev != nil {
+		for _, v := range prev.Violations {
+			prevSet[key(v)] = true
+		}
+	}
+
+	nextSet := map[string]bool{}
+	if next != nil {
+		for _, v := range next.Violations {
+			nextSet[key(v)] = true
+			if !prevSet[key(v)] {
+				added = append(added, v)
+			}
+		}
+	}
+
+	if prev != nil {
+		for _, v := range prev.Violations {
+			if !nextSet[key(v)] {
+				removed = append(removed, v)
+			}
+		}
+	}
+
+	return added, removed
+}
+
+// currentHeadSHA returns the commit SHA at HEAD of the repo at repoPath.
+func currentHeadSHA(repoPath string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = repoPath
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// currentBranch returns the branch checked out at repoPath.
+func currentBranch(repoPath string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
+	cmd.Dir = repoPath
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// fetchFastForward fetches branch from origin and fast-forwards the local
+// checkout to it.
+func fetchFastForward(repoPath, branch string) error {
+	fetch := exec.Command("git", "fetch", "origin", branch)
+	fetch.Dir = repoPath
+	if out, err := fetch.CombinedOutput(); err != nil {
+		return fmt.Errorf("git fetch failed: %s: %s", err.Error(), string(out))
+	}
+
+	merge := exec.Command("git", "merge", "--ff-only", "origin/"+branch)
+	merge.Dir = repoPath
+	if out, err := merge.CombinedOutput(); err != nil {
+		return fmt.Errorf("git merge --ff-only failed: %s: %s", err.Error(), string(out))
+	}
+
+	return nil
+}
+
+// startHealthServer starts the /healthz and /metrics endpoints in the
+// background so the container can run as a long-lived sidecar.
+func startHealthServer(metrics *watchMetrics) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	})
+
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		metrics.writePrometheus(w)
+	})
+
+	go http.ListenAndServe(":8080", mux)
+}
+
+// watchRun keeps the process alive after the initial clone+scan,
+// periodically fetching the configured branch and re-running phpmd in
+// incremental mode over whatever changed, until SIGINT/SIGTERM or until
+// fetch retries exceed the backoff's max elapsed time.
+func watchRun(codata map[string]string, suffixes []string, initialReport *Report) {
+	pollInterval := defaultPollInterval
+	if codata["poll-interval"] != "" {
+		if seconds, err := strconv.Atoi(codata["poll-interval"]); err == nil && seconds > 0 {
+			pollInterval = time.Duration(seconds) * time.Second
+		} else {
+			fmt.Fprintf(os.Stderr, "[COUT] Ignoring invalid poll-interval %q, using default\n", codata["poll-interval"])
+		}
+	}
+
+	branch := codata["git-ref"]
+	if branch == "" {
+		if b, err := currentBranch(basePath); err == nil {
+			branch = b
+		}
+	}
+
+	metrics := &watchMetrics{}
+	if initialReport != nil {
+		metrics.record(0, len(initialReport.Violations))
+	}
+	startHealthServer(metrics)
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	retry := newBackoff()
+	lastReport := initialReport
+
+	for {
+		select {
+		case <-sigCh:
+			fmt.Fprintf(os.Stderr, "[COUT] Watch mode received shutdown signal, exiting\n")
+			return
+
+		case <-ticker.C:
+			headBefore, _ := currentHeadSHA(basePath)
+
+			if err := fetchFastForward(basePath, branch); err != nil {
+				delay, ok := retry.next()
+				fmt.Fprintf(os.Stderr, "[COUT] Watch fetch error: %s\n", err.Error())
+				if !ok {
+					fmt.Fprintf(os.Stderr, "[COUT] Giving up on watch mode after exceeding max retry window\n")
+					return
+				}
+				time.Sleep(delay)
+				continue
+			}
+			retry.reset()
+
+			headAfter, err := currentHeadSHA(basePath)
+			if err != nil || headAfter == headBefore {
+				continue
+			}
+
+			start := time.Now()
+
+			changed, _ := gitChangedFiles(basePath, headBefore)
+			changed = filterBySuffix(changed, suffixes)
+			existingChanged := filterExisting(basePath, changed)
+
+			var newReport *Report
+			switch {
+			case len(existingChanged) > 0:
+				if partial, err := runPHPMD(strings.Join(existingChanged, ","), codata); err == nil {
+					newReport = mergeReports(lastReport, partial, changed)
+				} else {
+					fmt.Fprintf(os.Stderr, "[COUT] Watch scan error, keeping prior report unchanged: %s\n", err.Error())
+					newReport = lastReport
+				}
+			case len(changed) > 0:
+				// Every changed file was a deletion: nothing to scan, but
+				// their violations still need pruning from the report.
+				newReport = mergeReports(lastReport, &Report{}, changed)
+			default:
+				newReport = lastReport
+			}
+
+			duration := time.Since(start)
+
+			added, removed := diffViolations(lastReport, newReport)
+			if len(added) > 0 || len(removed) > 0 {
+				line, err := json.Marshal(deltaEvent{HeadSHA: headAfter, Added: added, Removed: removed})
+				if err == nil {
+					fmt.Fprintln(os.Stdout, string(line))
+				}
+			}
+
+			lastReport = newReport
+			persistReport(lastReport, suffixes)
+
+			violations := 0
+			if lastReport != nil {
+				violations = len(lastReport.Violations)
+			}
+			metrics.record(duration, violations)
+		}
+	}
+}
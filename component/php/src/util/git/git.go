@@ -0,0 +1,264 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package git wraps the git binary for the handful of operations the
+// components need: cloning a remote repository into a local workspace.
+package git
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Credentials describes how to authenticate a clone against a private
+// host, either over HTTPS with a token or over SSH with a private key.
+// Only one of the two is expected to be set.
+type Credentials struct {
+	// TokenEnv is the name of the environment variable holding an HTTPS
+	// access token, injected into the clone URL as userinfo.
+	TokenEnv string
+	// SSHKeyPath points at a private key file used for SSH clones.
+	SSHKeyPath string
+}
+
+// CloneConfig controls how CloneWithConfig invokes git.
+type CloneConfig struct {
+	// Depth, when > 0, performs a shallow clone (--depth).
+	Depth int
+	// Branch checks out a specific branch instead of the default.
+	Branch string
+	// Tag checks out a specific tag instead of the default branch.
+	Tag string
+	// Ref checks out an arbitrary commit-ish after the clone completes,
+	// for callers that need a precise revision rather than a branch tip.
+	Ref string
+	// Recursive clones submodules along with the repository.
+	Recursive bool
+	// Quiet suppresses git's progress output.
+	Quiet bool
+	// SingleBranch restricts the clone to the branch/tag being fetched.
+	SingleBranch bool
+	// Credentials authenticates against private hosts. Nil means
+	// anonymous access, matching the previous behavior of Clone.
+	Credentials *Credentials
+	// ProxyURL, when set, is used for both HTTP_PROXY and HTTPS_PROXY.
+	ProxyURL string
+}
+
+// Clone clones url into dest with no special options, preserving the
+// original behavior of this package for callers that don't need the
+// extra knobs in CloneConfig.
+func Clone(url, dest string) error {
+	return CloneWithConfig(url, dest, CloneConfig{})
+}
+
+// CloneOrUpdate clones url into dest, or, if dest already holds a git
+// checkout from a previous run, fetches and fast-forwards it in place
+// instead of attempting a fresh clone into a non-empty directory.
+func CloneOrUpdate(url, dest string, cfg CloneConfig) error {
+	if isCloned(dest) {
+		return updateExisting(dest, cfg)
+	}
+
+	return CloneWithConfig(url, dest, cfg)
+}
+
+// isCloned reports whether dest already holds a git checkout.
+func isCloned(dest string) bool {
+	info, err := os.Stat(filepath.Join(dest, ".git"))
+	return err == nil && (info.IsDir() || info.Mode().IsRegular())
+}
+
+// updateExisting fetches origin and resets dest's working tree to the ref
+// configured in cfg (or origin's default branch when none is given),
+// without re-cloning.
+func updateExisting(dest string, cfg CloneConfig) error {
+	ref := cfg.Ref
+	if ref == "" {
+		ref = cfg.Branch
+	}
+	if ref == "" {
+		ref = cfg.Tag
+	}
+
+	fetchArgs := []string{"fetch"}
+	if cfg.Quiet {
+		fetchArgs = append(fetchArgs, "--quiet")
+	}
+	if cfg.Depth > 0 {
+		fetchArgs = append(fetchArgs, fmt.Sprintf("--depth=%d", cfg.Depth))
+	}
+	fetchArgs = append(fetchArgs, "origin")
+	if ref != "" {
+		fetchArgs = append(fetchArgs, ref)
+	}
+
+	token, env := credentialEnv(cfg)
+
+	fetch := exec.Command("git", fetchArgs...)
+	fetch.Dir = dest
+	fetch.Env = env
+
+	if out, err := fetch.CombinedOutput(); err != nil {
+		return fmt.Errorf("git fetch failed: %s: %s", err.Error(), redact(string(out), token))
+	}
+
+	resetTarget := "FETCH_HEAD"
+	if ref == "" {
+		resetTarget = "origin/HEAD"
+	}
+
+	reset := exec.Command("git", "reset", "--hard", resetTarget)
+	reset.Dir = dest
+
+	if out, err := reset.CombinedOutput(); err != nil {
+		return fmt.Errorf("git reset %s failed: %s: %s", resetTarget, err.Error(), redact(string(out), token))
+	}
+
+	return nil
+}
+
+// CloneWithConfig clones url into dest honoring cfg's depth, ref,
+// submodule, credentials and proxy settings.
+func CloneWithConfig(url, dest string, cfg CloneConfig) error {
+	cloneURL, token, err := withCredentials(url, cfg.Credentials)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"clone"}
+
+	if cfg.Quiet {
+		args = append(args, "--quiet")
+	}
+	if cfg.Depth > 0 {
+		args = append(args, fmt.Sprintf("--depth=%d", cfg.Depth))
+	}
+	if cfg.SingleBranch {
+		args = append(args, "--single-branch")
+	}
+	if cfg.Branch != "" {
+		args = append(args, "--branch", cfg.Branch)
+	} else if cfg.Tag != "" {
+		args = append(args, "--branch", cfg.Tag)
+	}
+	if cfg.Recursive {
+		args = append(args, "--recursive")
+	}
+
+	dest = normalizeHostPath(dest)
+	args = append(args, cloneURL, dest)
+
+	_, env := credentialEnv(cfg)
+
+	cmd := exec.Command("git", args...)
+	cmd.Env = env
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git clone failed: %s: %s", err.Error(), redact(string(out), token))
+	}
+
+	if cfg.Ref != "" {
+		checkout := exec.Command("git", "checkout", cfg.Ref)
+		checkout.Dir = dest
+
+		if out, err := checkout.CombinedOutput(); err != nil {
+			return fmt.Errorf("git checkout %s failed: %s: %s", cfg.Ref, err.Error(), redact(string(out), token))
+		}
+	}
+
+	return nil
+}
+
+// credentialEnv builds the environment a git subprocess should run with
+// for cfg: the ambient environment plus any proxy and SSH key settings.
+// It also returns the HTTPS token (if any) so callers can redact it from
+// command output before that output is logged.
+func credentialEnv(cfg CloneConfig) (token string, env []string) {
+	env = os.Environ()
+
+	if cfg.ProxyURL != "" {
+		env = append(env, "HTTPS_PROXY="+cfg.ProxyURL, "HTTP_PROXY="+cfg.ProxyURL)
+	}
+	if cfg.Credentials != nil {
+		if cfg.Credentials.SSHKeyPath != "" {
+			env = append(env, "GIT_SSH_COMMAND=ssh -i "+cfg.Credentials.SSHKeyPath+" -o StrictHostKeyChecking=no")
+		}
+		if cfg.Credentials.TokenEnv != "" {
+			token = os.Getenv(cfg.Credentials.TokenEnv)
+		}
+	}
+
+	return token, env
+}
+
+// withCredentials returns url rewritten to carry an HTTPS token, if creds
+// asks for one, along with the raw token so callers can redact it from any
+// command output before logging it. SSH key credentials don't touch the
+// URL; they're applied via GIT_SSH_COMMAND instead.
+func withCredentials(url string, creds *Credentials) (cloneURL string, token string, err error) {
+	if creds == nil || creds.TokenEnv == "" {
+		return url, "", nil
+	}
+
+	token = os.Getenv(creds.TokenEnv)
+	if token == "" {
+		return "", "", fmt.Errorf("git credentials: environment variable %q is not set", creds.TokenEnv)
+	}
+
+	if !strings.HasPrefix(url, "https://") {
+		return url, token, nil
+	}
+
+	return "https://" + token + "@" + strings.TrimPrefix(url, "https://"), token, nil
+}
+
+// redact strips a secret value out of s, so credentials embedded in a
+// clone URL never reach CI logs via a failing command's own output.
+func redact(s, secret string) string {
+	if secret == "" {
+		return s
+	}
+
+	return strings.ReplaceAll(s, secret, "***")
+}
+
+// normalizeHostPath converts POSIX-style Cygwin/MSYS paths (e.g.
+// "/cygdrive/c/ws") into native Windows paths so the same CO_DATA driven
+// path works whether the container runs under a Windows CI runner's
+// Cygwin/MSYS shell or a plain Linux one.
+func normalizeHostPath(path string) string {
+	if runtime.GOOS != "windows" {
+		return path
+	}
+
+	if strings.HasPrefix(path, "/cygdrive/") {
+		rest := strings.TrimPrefix(path, "/cygdrive/")
+		drive := rest[:1]
+		return strings.ToUpper(drive) + ":" + rest[1:]
+	}
+
+	if len(path) > 2 && path[0] == '/' && path[2] == '/' {
+		drive := path[1:2]
+		return strings.ToUpper(drive) + ":" + path[2:]
+	}
+
+	return path
+}